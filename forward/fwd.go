@@ -0,0 +1,504 @@
+// Package forward implements http handler that forwards requests to remote
+// server and serves back the response. Websocket requests are tunneled to
+// the backend over a raw, hijacked connection.
+package forward
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gravitational/oxy/utils"
+)
+
+// ReqRewriter can alter request headers and body
+type ReqRewriter interface {
+	Rewrite(r *http.Request)
+}
+
+type optSetter func(f *Forwarder) error
+
+// PassHostHeader specifies if a client's Host header field should be
+// delegated to the backend instead of being rewritten to the backend's host.
+func PassHostHeader(b bool) optSetter {
+	return func(f *Forwarder) error {
+		f.passHost = b
+		return nil
+	}
+}
+
+// RoundTripper sets a new http.RoundTripper.
+// Forwarder will use http.DefaultTransport as a default round tripper.
+func RoundTripper(r http.RoundTripper) optSetter {
+	return func(f *Forwarder) error {
+		f.roundTripper = r
+		return nil
+	}
+}
+
+// Rewriter defines a request rewriter applied to the outbound request before
+// it is sent to the backend.
+func Rewriter(r ReqRewriter) optSetter {
+	return func(f *Forwarder) error {
+		f.rewriter = r
+		return nil
+	}
+}
+
+// RequestModifier sets a function that runs on the outbound request after
+// Rewriter but before the RoundTripper.
+func RequestModifier(m func(*http.Request)) optSetter {
+	return func(f *Forwarder) error {
+		f.reqModifier = m
+		return nil
+	}
+}
+
+// ResponseModifier sets a function that runs on the backend's response
+// before it is written to the client, mirroring ReverseProxy.ModifyResponse.
+func ResponseModifier(m func(*http.Response) error) optSetter {
+	return func(f *Forwarder) error {
+		f.respModifier = m
+		return nil
+	}
+}
+
+// PreserveChunkedTransferEncoding streams a chunked backend response to the
+// client as it arrives instead of buffering it to compute Content-Length.
+func PreserveChunkedTransferEncoding(b bool) optSetter {
+	return func(f *Forwarder) error {
+		f.preserveChunked = b
+		return nil
+	}
+}
+
+// ErrorHandler is a functional argument that sets the error handler of the forwarder
+func ErrorHandler(h utils.ErrorHandler) optSetter {
+	return func(f *Forwarder) error {
+		f.errHandler = h
+		return nil
+	}
+}
+
+// Logger specifies the logger to use.
+// Forwarder will use utils.NullLogger as a default logger if not specified.
+func Logger(l utils.Logger) optSetter {
+	return func(f *Forwarder) error {
+		f.log = l
+		return nil
+	}
+}
+
+// FlushInterval specifies the flush interval to flush to the client while
+// copying the response body. Zero (the default) buffers the whole body and
+// serves it with a Content-Length; a negative value streams it and flushes
+// only once, after the copy is done.
+func FlushInterval(d time.Duration) optSetter {
+	return func(f *Forwarder) error {
+		f.flushInterval = d
+		return nil
+	}
+}
+
+// ForceStreaming disables response buffering: the body is written to the
+// client as it is read from the backend and flushed at every "\n\n" event
+// terminator and at the end. It's implied automatically for
+// text/event-stream responses.
+func ForceStreaming(b bool) optSetter {
+	return func(f *Forwarder) error {
+		f.forceStreaming = b
+		return nil
+	}
+}
+
+// StateListener is a functional argument that sets the forwarder's backend
+// state listener, notified whenever the forwarder is about to dial a backend URL.
+func StateListener(stateListener UrlForwardingStateListener) optSetter {
+	return func(f *Forwarder) error {
+		f.stateListener = stateListener
+		return nil
+	}
+}
+
+// UrlForwardingStateListener is a listener notified when the forwarder is
+// about to send a request to a backend URL.
+type UrlForwardingStateListener func(newURL *url.URL)
+
+// Forwarder wraps a http.Handler and forwards requests to the URL set on the
+// incoming request, using the configured RoundTripper and ReqRewriter.
+type Forwarder struct {
+	roundTripper      http.RoundTripper
+	rewriter          ReqRewriter
+	reqModifier       func(*http.Request)
+	respModifier      func(*http.Response) error
+	passHost          bool
+	flushInterval     time.Duration
+	preserveChunked   bool
+	forceStreaming    bool
+	retryPolicy       RetryPolicy
+	maxRetryBodyBytes int64
+	metrics           MetricsSink
+	tracer            func() *httptrace.ClientTrace
+	errHandler        utils.ErrorHandler
+	log               utils.Logger
+	stateListener     UrlForwardingStateListener
+}
+
+// New creates an instance of Forwarder based on the provided list of configuration options
+func New(setters ...optSetter) (*Forwarder, error) {
+	f := &Forwarder{
+		log: &utils.NoopLogger{},
+	}
+	for _, s := range setters {
+		if err := s(f); err != nil {
+			return nil, err
+		}
+	}
+	if f.roundTripper == nil {
+		f.roundTripper = http.DefaultTransport
+	}
+	if f.rewriter == nil {
+		h, err := os.Hostname()
+		if err != nil {
+			h = "localhost"
+		}
+		f.rewriter = &HeaderRewriter{Hostname: h}
+	}
+	if f.errHandler == nil {
+		f.errHandler = utils.DefaultHandler
+	}
+	return f, nil
+}
+
+// ServeHTTP decides which forwarder to use based on the specified
+// request and delegates to the proper implementation
+func (f *Forwarder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case isWebsocketRequest(req) || isExtendedConnect(req):
+		f.serveWebSocket(w, req)
+	default:
+		f.serveHTTP(w, req)
+	}
+}
+
+// Hop-by-hop headers. These are removed when sent to the backend.
+// http://www.w3.org/Protocols/rfc2616/rfc2616-sec13.html
+const (
+	Connection         = "Connection"
+	KeepAlive          = "Keep-Alive"
+	ProxyAuthenticate  = "Proxy-Authenticate"
+	ProxyAuthorization = "Proxy-Authorization"
+	Te                 = "Te" // canonicalized version of "TE"
+	Trailers           = "Trailers"
+	TransferEncoding   = "Transfer-Encoding"
+	Upgrade            = "Upgrade"
+
+	XForwardedProto  = "X-Forwarded-Proto"
+	XForwardedFor    = "X-Forwarded-For"
+	XForwardedHost   = "X-Forwarded-Host"
+	XForwardedServer = "X-Forwarded-Server"
+	XForwardedPort   = "X-Forwarded-Port"
+)
+
+// HopHeaders are stripped before forwarding the request.
+var HopHeaders = []string{
+	Connection,
+	KeepAlive,
+	ProxyAuthenticate,
+	ProxyAuthorization,
+	Te,
+	Trailers,
+	TransferEncoding,
+	Upgrade,
+}
+
+func isWebsocketRequest(req *http.Request) bool {
+	containsHeader := func(name, value string) bool {
+		for _, item := range strings.Split(req.Header.Get(name), ",") {
+			if value == strings.ToLower(strings.TrimSpace(item)) {
+				return true
+			}
+		}
+		return false
+	}
+	return containsHeader(Connection, "upgrade") && containsHeader(Upgrade, "websocket")
+}
+
+func copyHeaders(dst, src http.Header) {
+	for k, vv := range src {
+		for _, v := range vv {
+			dst.Add(k, v)
+		}
+	}
+}
+
+func removeHeaders(headers http.Header, names ...string) {
+	for _, h := range names {
+		headers.Del(h)
+	}
+}
+
+// copyRequest builds the outbound request sent to the backend, stripping
+// hop-by-hop headers along the way.
+func (f *Forwarder) copyRequest(req *http.Request) *http.Request {
+	outReq := new(http.Request)
+	*outReq = *req
+
+	outReq.URL = utils.CopyURL(req.URL)
+	outReq.Proto = "HTTP/1.1"
+	outReq.ProtoMajor = 1
+	outReq.ProtoMinor = 1
+
+	if !f.passHost {
+		outReq.Host = outReq.URL.Host
+	}
+
+	outReq.Header = make(http.Header)
+	copyHeaders(outReq.Header, req.Header)
+
+	if !isWebsocketRequest(req) {
+		removeHeaders(outReq.Header, HopHeaders...)
+
+		// https://tools.ietf.org/html/rfc7230#section-6.1
+		if connection := req.Header.Get(Connection); connection != "" {
+			removeHeaders(outReq.Header, strings.Split(connection, ",")...)
+		}
+	}
+
+	return outReq
+}
+
+func (f *Forwarder) serveHTTP(w http.ResponseWriter, inReq *http.Request) {
+	if f.stateListener != nil {
+		f.stateListener(inReq.URL)
+	}
+
+	if f.retryPolicy != nil {
+		bufferRetryableBody(inReq, f.maxRetryBodyBytes)
+	}
+
+	outReq := f.copyRequest(inReq)
+	// Inherit the incoming request's context so a client disconnect cancels
+	// the outbound round trip.
+	outReq = outReq.WithContext(f.traceContext(inReq.Context(), outReq.URL.Host))
+	if f.rewriter != nil {
+		f.rewriter.Rewrite(outReq)
+	}
+	if f.reqModifier != nil {
+		f.reqModifier(outReq)
+	}
+
+	f.log.Infof("vulcand/oxy/forward: begin ServeHttp on request: %v", inReq.URL)
+	resp, err := f.roundTrip(inReq, outReq)
+	if err != nil {
+		f.log.Errorf("vulcand/oxy/forward: Error forwarding to %v, err: %v", inReq.URL, err)
+		f.emitError(outReq.URL.Host, err)
+		f.errHandler.ServeHTTP(w, inReq, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if f.respModifier != nil {
+		if err := f.respModifier(resp); err != nil {
+			f.log.Errorf("vulcand/oxy/forward: ResponseModifier rejected response from %v, err: %v", inReq.URL, err)
+			f.errHandler.ServeHTTP(w, inReq, err)
+			return
+		}
+	}
+
+	copyHeaders(w.Header(), resp.Header)
+	removeHeaders(w.Header(), HopHeaders...)
+
+	streaming := f.forceStreaming || isEventStream(resp.Header.Get("Content-Type"))
+	if streaming || f.flushInterval != 0 || f.preserveChunked {
+		// The body may have been swapped out by a ResponseModifier, so its
+		// final length isn't known up front: let it flow to the client
+		// chunked rather than serving a stale or absent Content-Length.
+		w.Header().Del("Content-Length")
+		if streaming {
+			// Tell intermediate proxies (notably nginx) not to buffer the
+			// response, since that would defeat per-event flushing just as
+			// surely as buffering it ourselves would.
+			w.Header().Set("X-Accel-Buffering", "no")
+		}
+		w.WriteHeader(resp.StatusCode)
+		var n int64
+		if streaming {
+			n = f.copyEventStream(w, resp.Body)
+		} else {
+			n = f.copyWithFlush(w, resp.Body)
+		}
+		f.emitBytes(EventBytesWritten, outReq.URL.Host, n)
+		copyTrailer(w, resp)
+		return
+	}
+
+	// Buffer the whole body so that a chunked backend response can be served
+	// to the client with an accurate Content-Length instead of re-chunking it.
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		f.log.Errorf("vulcand/oxy/forward: Error reading response body from %v, err: %v", inReq.URL, err)
+		f.errHandler.ServeHTTP(w, inReq, err)
+		return
+	}
+	f.emitBytes(EventBytesRead, outReq.URL.Host, int64(len(body)))
+	copyTrailer(w, resp)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	w.WriteHeader(resp.StatusCode)
+	n, _ := w.Write(body)
+	f.emitBytes(EventBytesWritten, outReq.URL.Host, int64(n))
+}
+
+func (f *Forwarder) copyWithFlush(dst http.ResponseWriter, src io.Reader) int64 {
+	flusher, ok := dst.(http.Flusher)
+	if !ok || f.flushInterval <= 0 {
+		n, _ := io.Copy(dst, src)
+		if ok {
+			flusher.Flush()
+		}
+		return n
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(f.flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				flusher.Flush()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	n, _ := io.Copy(dst, src)
+	flusher.Flush()
+	return n
+}
+
+// isEventStream reports whether a response Content-Type value identifies a
+// Server-Sent Events stream.
+func isEventStream(contentType string) bool {
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	return strings.EqualFold(mediaType, "text/event-stream")
+}
+
+// copyEventStream streams src to dst a read at a time, flushing after any read
+// that contains a blank-line event terminator ("\n\n"), including one split
+// across two reads.
+func (f *Forwarder) copyEventStream(dst http.ResponseWriter, src io.Reader) int64 {
+	flusher, ok := dst.(http.Flusher)
+	if !ok {
+		n, _ := io.Copy(dst, src)
+		return n
+	}
+
+	var total int64
+	var prevByte byte
+	buf := make([]byte, 4096)
+	for {
+		nr, rerr := src.Read(buf)
+		if nr > 0 {
+			chunk := buf[:nr]
+			nw, werr := dst.Write(chunk)
+			total += int64(nw)
+			if bytes.Contains(chunk, []byte("\n\n")) || (prevByte == '\n' && chunk[0] == '\n') {
+				flusher.Flush()
+			}
+			prevByte = chunk[len(chunk)-1]
+			if werr != nil {
+				break
+			}
+		}
+		if rerr != nil {
+			break
+		}
+	}
+	flusher.Flush()
+	return total
+}
+
+func (f *Forwarder) serveWebSocket(w http.ResponseWriter, req *http.Request) {
+	if isExtendedConnect(req) {
+		f.serveFullDuplex(w, req)
+		return
+	}
+
+	if f.stateListener != nil {
+		f.stateListener(req.URL)
+	}
+
+	outReq := f.copyRequest(req)
+	if f.rewriter != nil {
+		f.rewriter.Rewrite(outReq)
+	}
+
+	targetConn, err := net.DialTimeout("tcp", outReq.URL.Host, 30*time.Second)
+	if err != nil {
+		f.log.Errorf("vulcand/oxy/forward: Error dialing websocket backend %v: %v", outReq.URL, err)
+		f.emitWebsocketUpgrade(outReq.URL.Host, false)
+		f.errHandler.ServeHTTP(w, req, err)
+		return
+	}
+	defer targetConn.Close()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		f.errHandler.ServeHTTP(w, req, fmt.Errorf("websocket: response writer does not support hijacking"))
+		return
+	}
+
+	underlyingConn, _, err := hijacker.Hijack()
+	if err != nil {
+		f.errHandler.ServeHTTP(w, req, err)
+		return
+	}
+	defer underlyingConn.Close()
+
+	if err := outReq.Write(targetConn); err != nil {
+		f.log.Errorf("vulcand/oxy/forward: Error writing websocket request to backend: %v", err)
+		return
+	}
+
+	// Read the handshake response before relaying raw bytes, in case the
+	// backend refuses the upgrade.
+	backendReader := bufio.NewReader(targetConn)
+	resp, err := http.ReadResponse(backendReader, outReq)
+	if err != nil {
+		f.log.Errorf("vulcand/oxy/forward: Error reading websocket handshake response from backend: %v", err)
+		f.emitWebsocketUpgrade(outReq.URL.Host, false)
+		return
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		f.emitWebsocketUpgrade(outReq.URL.Host, false)
+		resp.Write(underlyingConn)
+		resp.Body.Close()
+		return
+	}
+	if err := resp.Write(underlyingConn); err != nil {
+		f.log.Errorf("vulcand/oxy/forward: Error writing websocket handshake response to client: %v", err)
+		return
+	}
+	f.emitWebsocketUpgrade(outReq.URL.Host, true)
+
+	errc := make(chan error, 2)
+	replicate := func(dst io.Writer, src io.Reader, kind EventKind) {
+		n, err := io.Copy(dst, src)
+		f.emitBytes(kind, outReq.URL.Host, n)
+		errc <- err
+	}
+	go replicate(targetConn, underlyingConn, EventBytesWritten)
+	go replicate(underlyingConn, backendReader, EventBytesRead)
+	<-errc
+}
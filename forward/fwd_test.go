@@ -3,6 +3,7 @@ package forward
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -371,6 +372,181 @@ func (s *FwdSuite) TestChunkedResponseConversion(c *C) {
 	c.Assert(re.Header.Get("Content-Length"), Equals, fmt.Sprintf("%d", len(expected)))
 }
 
+// Makes sure RequestModifier runs after Rewriter and can still edit the request
+func TestRequestModifierRunsAfterRewriter(t *testing.T) {
+	var outHeader string
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		outHeader = req.Header.Get("X-Modified")
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	f, err := New(
+		Rewriter(&HeaderRewriter{Hostname: "rewriter-host"}),
+		RequestModifier(func(req *http.Request) {
+			// Rewriter must have already run by the time RequestModifier sees
+			// the request.
+			require.NotEmpty(t, req.Header.Get(XForwardedFor))
+			req.Header.Set("X-Modified", "yes")
+		}),
+	)
+	require.NoError(t, err)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, re.StatusCode)
+	require.Equal(t, "hello", string(body))
+	require.Equal(t, "yes", outHeader)
+}
+
+// Makes sure ResponseModifier's body swap recomputes Content-Length
+func TestResponseModifierRecomputesContentLength(t *testing.T) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	f, err := New(ResponseModifier(func(resp *http.Response) error {
+		resp.Body = io.NopCloser(strings.NewReader("hello, rewritten"))
+		return nil
+	}))
+	require.NoError(t, err)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, re.StatusCode)
+	require.Equal(t, "hello, rewritten", string(body))
+	require.Equal(t, fmt.Sprintf("%d", len("hello, rewritten")), re.Header.Get("Content-Length"))
+}
+
+// Makes sure ResponseModifier's body swap is also honored on the streaming path
+func TestResponseModifierOnStreamingPath(t *testing.T) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	f, err := New(
+		FlushInterval(time.Millisecond),
+		ResponseModifier(func(resp *http.Response) error {
+			resp.Body = io.NopCloser(strings.NewReader("hello, streamed"))
+			return nil
+		}),
+	)
+	require.NoError(t, err)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, re.StatusCode)
+	require.Equal(t, "hello, streamed", string(body))
+	require.Equal(t, "", re.Header.Get("Content-Length"))
+}
+
+// Makes sure PreserveChunkedTransferEncoding streams instead of buffering
+func TestPreserveChunkedTransferEncoding(t *testing.T) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		h := w.(http.Hijacker)
+		conn, _, _ := h.Hijack()
+		data := "HTTP/1.1 200 OK\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"0a\r\n" +
+			"Body here\n\r\n" +
+			"09\r\n" +
+			"continued\r\n" +
+			"0\r\n" +
+			"\r\n"
+		fmt.Fprintf(conn, data)
+		conn.Close()
+	})
+	defer srv.Close()
+
+	f, err := New(PreserveChunkedTransferEncoding(true))
+	require.NoError(t, err)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, re.StatusCode)
+	require.Equal(t, "Body here\ncontinued", string(body))
+	require.Equal(t, "", re.Header.Get("Content-Length"))
+}
+
+// Makes sure a text/event-stream response is auto-flushed without FlushInterval
+func (s *FwdSuite) TestEventStreamAutoFlush(c *C) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		h := w.(http.Hijacker)
+		conn, _, _ := h.Hijack()
+		defer conn.Close()
+		data := "HTTP/1.1 200 OK\r\n" +
+			"Content-Type: text/event-stream\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"d\r\n" +
+			"data: first\n\n\r\n"
+		fmt.Fprintf(conn, data)
+		time.Sleep(50 * time.Millisecond)
+		data = "e\r\n" +
+			"data: second\n\n\r\n" +
+			"0\r\n" +
+			"\r\n"
+		fmt.Fprintf(conn, data)
+	})
+	defer srv.Close()
+
+	// No FlushInterval configured: auto-detection of the event-stream
+	// content type must switch on per-event flushing by itself.
+	f, err := New()
+	c.Assert(err, IsNil)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	request, err := http.NewRequest("GET", proxy.URL, nil)
+	c.Assert(err, IsNil)
+	re, err := http.DefaultClient.Do(request)
+	c.Assert(err, IsNil)
+	c.Assert(re.Header.Get("X-Accel-Buffering"), Equals, "no")
+
+	buffer := make([]byte, 4096)
+loop:
+	for {
+		n, err := re.Body.Read(buffer)
+		if n != 0 && strings.Contains(string(buffer[:n]), "data: first") {
+			break loop
+		}
+		if err != nil {
+			c.Fatalf("Timeout waiting for the first event to arrive: %v", err)
+		}
+	}
+}
+
 func (s *FwdSuite) TestDetectsWebsocketRequest(c *C) {
 	mux := http.NewServeMux()
 	mux.Handle("/ws", websocket.Handler(func(conn *websocket.Conn) {
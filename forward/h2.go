@@ -0,0 +1,146 @@
+package forward
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+)
+
+// HTTP2 configures the Forwarder to speak HTTP/2 (with TLS, via ALPN) to the
+// backend, using the supplied *http2.Transport as the round tripper.
+func HTTP2(t *http2.Transport) optSetter {
+	return func(f *Forwarder) error {
+		f.roundTripper = t
+		return nil
+	}
+}
+
+// H2C configures the Forwarder to speak cleartext HTTP/2 ("h2c", prior
+// knowledge) to the backend instead of HTTP/1.1.
+func H2C(enable bool) optSetter {
+	return func(f *Forwarder) error {
+		if !enable {
+			return nil
+		}
+		f.roundTripper = &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		}
+		return nil
+	}
+}
+
+// TrailerHeaders are stripped from the response trailer before it's
+// forwarded, like HopHeaders is for the response header.
+var TrailerHeaders = []string{
+	Connection,
+	KeepAlive,
+	ProxyAuthenticate,
+	ProxyAuthorization,
+	Te,
+	Trailers,
+	TransferEncoding,
+	Upgrade,
+}
+
+// copyTrailer forwards the response trailer to the client under the
+// http.TrailerPrefix key, since it isn't known until after WriteHeader.
+func copyTrailer(dst http.ResponseWriter, resp *http.Response) {
+	if len(resp.Trailer) == 0 {
+		return
+	}
+	removeHeaders(resp.Trailer, TrailerHeaders...)
+	for k, values := range resp.Trailer {
+		for _, v := range values {
+			dst.Header().Add(http.TrailerPrefix+k, v)
+		}
+	}
+}
+
+// isExtendedConnect reports whether req is an RFC 8441 Extended CONNECT
+// request, i.e. a CONNECT carrying :protocol.
+func isExtendedConnect(req *http.Request) bool {
+	return req.Method == http.MethodConnect && req.Header.Get(":protocol") != ""
+}
+
+// serveFullDuplex tunnels an HTTP/2 Extended CONNECT stream to the backend
+// through the configured RoundTripper, since an h2 stream's ResponseWriter
+// can't be hijacked into a raw net.Conn like the HTTP/1.1 websocket path.
+func (f *Forwarder) serveFullDuplex(w http.ResponseWriter, req *http.Request) {
+	if f.stateListener != nil {
+		f.stateListener(req.URL)
+	}
+
+	outReq := f.copyRequest(req)
+	outReq = outReq.WithContext(f.traceContext(req.Context(), outReq.URL.Host))
+	outReq.Method = http.MethodConnect
+	outReq.Header.Set(":protocol", req.Header.Get(":protocol"))
+	outReq.Body = &countingReadCloser{ReadCloser: req.Body, onClose: func(n int64) {
+		f.emitBytes(EventBytesWritten, outReq.URL.Host, n)
+	}}
+	if f.rewriter != nil {
+		f.rewriter.Rewrite(outReq)
+	}
+
+	resp, err := f.roundTripper.RoundTrip(outReq)
+	if err != nil {
+		f.log.Errorf("vulcand/oxy/forward: Error forwarding extended CONNECT to %v: %v", outReq.URL, err)
+		f.emitError(outReq.URL.Host, err)
+		f.errHandler.ServeHTTP(w, req, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	upgraded := resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices
+	f.emitWebsocketUpgrade(outReq.URL.Host, upgraded)
+
+	copyHeaders(w.Header(), resp.Header)
+	removeHeaders(w.Header(), HopHeaders...)
+	w.WriteHeader(resp.StatusCode)
+
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	n, _ := io.Copy(flushWriter{w, flusher}, resp.Body)
+	f.emitBytes(EventBytesRead, outReq.URL.Host, n)
+}
+
+// countingReadCloser wraps a request body to report the total bytes read
+// from it once it's closed.
+type countingReadCloser struct {
+	io.ReadCloser
+	n       int64
+	onClose func(n int64)
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReadCloser) Close() error {
+	c.onClose(c.n)
+	return c.ReadCloser.Close()
+}
+
+// flushWriter flushes after every write so a streamed h2 response reaches
+// the client without waiting for the stream to end.
+type flushWriter struct {
+	w http.ResponseWriter
+	f http.Flusher
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if fw.f != nil {
+		fw.f.Flush()
+	}
+	return n, err
+}
@@ -0,0 +1,143 @@
+package forward
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gravitational/oxy/testutils"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// Makes sure H2C(true) negotiates prior-knowledge cleartext HTTP/2
+func TestForwardsH2CTraffic(t *testing.T) {
+	h2s := &http2.Server{}
+	srv := httptest.NewServer(h2c.NewHandler(testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		require.Equal(t, 2, req.ProtoMajor)
+		w.Write([]byte("hello"))
+	}), h2s))
+	defer srv.Close()
+
+	f, err := New(H2C(true))
+	require.NoError(t, err)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, re.StatusCode)
+	require.Equal(t, "hello", string(body))
+}
+
+// Makes sure trailers from an HTTP/2 backend survive the round trip
+func TestForwardsH2Trailers(t *testing.T) {
+	h2s := &http2.Server{}
+	srv := httptest.NewServer(h2c.NewHandler(testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Write([]byte("hello"))
+		w.Header().Set("X-Checksum", "deadbeef")
+	}), h2s))
+	defer srv.Close()
+
+	f, err := New(H2C(true), FlushInterval(-1))
+	require.NoError(t, err)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(body))
+	require.Equal(t, "deadbeef", resp.Trailer.Get("X-Checksum"))
+}
+
+// Makes sure serveFullDuplex tunnels a WebSocket-like stream over RFC 8441
+// Extended CONNECT end-to-end
+func TestForwardsWebSocketOverExtendedConnect(t *testing.T) {
+	backendH2s := &http2.Server{}
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		require.True(t, isExtendedConnect(req))
+		flusher := w.(http.Flusher)
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		buf := make([]byte, 1024)
+		for {
+			n, err := req.Body.Read(buf)
+			if n > 0 {
+				w.Write(buf[:n])
+				flusher.Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}), backendH2s))
+	defer backend.Close()
+
+	f, err := New(H2C(true))
+	require.NoError(t, err)
+
+	proxyH2s := &http2.Server{}
+	proxy := httptest.NewServer(h2c.NewHandler(testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(backend.URL)
+		f.ServeHTTP(w, req)
+	}), proxyH2s))
+	defer proxy.Close()
+
+	client := &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}
+
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodConnect, "http://"+proxy.Listener.Addr().String()+"/ws", pr)
+	require.NoError(t, err)
+	req.Header.Set(":protocol", "websocket")
+
+	resp, err := client.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, err = pw.Write([]byte("echo"))
+	require.NoError(t, err)
+
+	received := make([]byte, 4)
+	_, err = io.ReadFull(resp.Body, received)
+	require.NoError(t, err)
+	require.Equal(t, "echo", string(received))
+
+	require.NoError(t, pw.Close())
+}
+
+// TestExtendedConnectDetection proves isExtendedConnect only recognizes a
+// CONNECT request that actually carries the RFC 8441 :protocol pseudo-header.
+func TestExtendedConnectDetection(t *testing.T) {
+	req, err := http.NewRequest(http.MethodConnect, "https://example.com", nil)
+	require.NoError(t, err)
+	require.False(t, isExtendedConnect(req))
+
+	req.Header.Set(":protocol", "websocket")
+	require.True(t, isExtendedConnect(req))
+
+	req2, err := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	require.NoError(t, err)
+	require.False(t, isExtendedConnect(req2))
+}
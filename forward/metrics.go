@@ -0,0 +1,134 @@
+package forward
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"time"
+)
+
+// EventKind identifies the kind of observability event a MetricsSink
+// receives from a Forwarder.
+type EventKind string
+
+// Event kinds emitted by the Forwarder. The DNS, connect, TLS and first-byte
+// kinds come from its built-in httptrace wiring.
+const (
+	EventDNSStart          EventKind = "dns_start"
+	EventDNSDone           EventKind = "dns_done"
+	EventConnectStart      EventKind = "connect_start"
+	EventConnectDone       EventKind = "connect_done"
+	EventTLSHandshakeDone  EventKind = "tls_handshake_done"
+	EventFirstResponseByte EventKind = "first_response_byte"
+	EventBytesRead         EventKind = "bytes_read"
+	EventBytesWritten      EventKind = "bytes_written"
+	EventWebsocketUpgrade  EventKind = "websocket_upgrade"
+	EventError             EventKind = "error"
+)
+
+// Event is a single observability data point for one outbound request.
+type Event struct {
+	Kind     EventKind
+	Backend  string // backend host, e.g. outReq.URL.Host
+	Status   int    // response status, when known
+	Duration time.Duration
+	Bytes    int64
+	Success  bool
+	Err      error
+}
+
+// MetricsSink receives structured events about requests forwarded to a
+// backend. Implementations must be safe for concurrent use, since events for
+// concurrent requests can be emitted from different goroutines.
+type MetricsSink interface {
+	Emit(Event)
+}
+
+// Metrics wires a MetricsSink into the Forwarder, reporting DNS, connect,
+// TLS and TTFB timings, bytes transferred, and terminal error class.
+func Metrics(sink MetricsSink) optSetter {
+	return func(f *Forwarder) error {
+		f.metrics = sink
+		return nil
+	}
+}
+
+// Tracer installs an additional *httptrace.ClientTrace on every outbound
+// request, composed with the one the Forwarder builds for Metrics.
+func Tracer(newTrace func() *httptrace.ClientTrace) optSetter {
+	return func(f *Forwarder) error {
+		f.tracer = newTrace
+		return nil
+	}
+}
+
+// traceContext returns ctx with a composed *httptrace.ClientTrace installed,
+// reporting to f.metrics (when set) and to f.tracer's trace (when set).
+func (f *Forwarder) traceContext(ctx context.Context, backend string) context.Context {
+	var traces []*httptrace.ClientTrace
+	if f.metrics != nil {
+		traces = append(traces, f.metricsTrace(backend))
+	}
+	if f.tracer != nil {
+		traces = append(traces, f.tracer())
+	}
+	for _, t := range traces {
+		ctx = httptrace.WithClientTrace(ctx, t)
+	}
+	return ctx
+}
+
+func (f *Forwarder) metricsTrace(backend string) *httptrace.ClientTrace {
+	sink := f.metrics
+	var dnsStart, connectStart, tlsStart, reqStart time.Time
+	reqStart = time.Now()
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			dnsStart = time.Now()
+			sink.Emit(Event{Kind: EventDNSStart, Backend: backend})
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			sink.Emit(Event{Kind: EventDNSDone, Backend: backend, Duration: time.Since(dnsStart), Err: info.Err, Success: info.Err == nil})
+		},
+		ConnectStart: func(network, addr string) {
+			connectStart = time.Now()
+			sink.Emit(Event{Kind: EventConnectStart, Backend: backend})
+		},
+		ConnectDone: func(network, addr string, err error) {
+			sink.Emit(Event{Kind: EventConnectDone, Backend: backend, Duration: time.Since(connectStart), Err: err, Success: err == nil})
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			sink.Emit(Event{Kind: EventTLSHandshakeDone, Backend: backend, Duration: time.Since(tlsStart), Err: err, Success: err == nil})
+		},
+		GotFirstResponseByte: func() {
+			sink.Emit(Event{Kind: EventFirstResponseByte, Backend: backend, Duration: time.Since(reqStart), Success: true})
+		},
+	}
+}
+
+// emitError reports a terminal error class to the configured MetricsSink, a
+// no-op when Metrics wasn't configured.
+func (f *Forwarder) emitError(backend string, err error) {
+	if f.metrics != nil {
+		f.metrics.Emit(Event{Kind: EventError, Backend: backend, Err: err})
+	}
+}
+
+// emitBytes reports bytes transferred in either direction to the configured
+// MetricsSink, a no-op when Metrics wasn't configured.
+func (f *Forwarder) emitBytes(kind EventKind, backend string, n int64) {
+	if f.metrics != nil && n > 0 {
+		f.metrics.Emit(Event{Kind: kind, Backend: backend, Bytes: n, Success: true})
+	}
+}
+
+// emitWebsocketUpgrade reports whether a websocket upgrade to backend
+// succeeded, a no-op when Metrics wasn't configured.
+func (f *Forwarder) emitWebsocketUpgrade(backend string, success bool) {
+	if f.metrics != nil {
+		f.metrics.Emit(Event{Kind: EventWebsocketUpgrade, Backend: backend, Success: success})
+	}
+}
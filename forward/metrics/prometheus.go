@@ -0,0 +1,93 @@
+// Package metrics provides a ready-made forward.MetricsSink backed by
+// Prometheus client metrics.
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/gravitational/oxy/forward"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusSink is a forward.MetricsSink that records request timings and
+// byte counts as Prometheus histograms and counters, labeled by backend host
+// and (where applicable) response status class.
+type PrometheusSink struct {
+	backendLatency  *prometheus.HistogramVec
+	bytesTransfered *prometheus.CounterVec
+	wsUpgrades      *prometheus.CounterVec
+	errors          *prometheus.CounterVec
+}
+
+// NewPrometheusSink creates a PrometheusSink and registers its collectors
+// with reg. namespace and subsystem are used as-is to build the metric
+// names, following the usual Prometheus client convention.
+func NewPrometheusSink(reg prometheus.Registerer, namespace, subsystem string) (*PrometheusSink, error) {
+	s := &PrometheusSink{
+		backendLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "backend_duration_seconds",
+			Help:      "Time spent on DNS, connect, TLS handshake and time-to-first-byte phases of a backend request.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"backend", "phase"}),
+		bytesTransfered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "backend_bytes_total",
+			Help:      "Bytes transferred to or from a backend.",
+		}, []string{"backend", "direction"}),
+		wsUpgrades: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "websocket_upgrades_total",
+			Help:      "Websocket upgrade attempts to a backend, by outcome.",
+		}, []string{"backend", "outcome"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "backend_errors_total",
+			Help:      "Terminal errors forwarding a request to a backend, by error class.",
+		}, []string{"backend", "class"}),
+	}
+
+	for _, c := range []prometheus.Collector{s.backendLatency, s.bytesTransfered, s.wsUpgrades, s.errors} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Emit implements forward.MetricsSink.
+func (s *PrometheusSink) Emit(e forward.Event) {
+	switch e.Kind {
+	case forward.EventDNSDone, forward.EventConnectDone, forward.EventTLSHandshakeDone, forward.EventFirstResponseByte:
+		s.backendLatency.WithLabelValues(e.Backend, string(e.Kind)).Observe(e.Duration.Seconds())
+	case forward.EventBytesRead:
+		s.bytesTransfered.WithLabelValues(e.Backend, "read").Add(float64(e.Bytes))
+	case forward.EventBytesWritten:
+		s.bytesTransfered.WithLabelValues(e.Backend, "written").Add(float64(e.Bytes))
+	case forward.EventWebsocketUpgrade:
+		s.wsUpgrades.WithLabelValues(e.Backend, outcome(e.Success)).Inc()
+	case forward.EventError:
+		s.errors.WithLabelValues(e.Backend, errorClass(e)).Inc()
+	}
+}
+
+func outcome(success bool) string {
+	if success {
+		return "success"
+	}
+	return "upgrade_failed"
+}
+
+func errorClass(e forward.Event) string {
+	if e.Status != 0 {
+		return strconv.Itoa(e.Status/100) + "xx"
+	}
+	if e.Err == nil {
+		return "unknown"
+	}
+	return "transport_error"
+}
@@ -0,0 +1,186 @@
+package forward
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gravitational/oxy/testutils"
+	"github.com/stretchr/testify/require"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/websocket"
+)
+
+// recordingSink is a MetricsSink that records every Event it receives, safe
+// for concurrent use since the websocket path emits from two goroutines.
+type recordingSink struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (s *recordingSink) Emit(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+}
+
+func (s *recordingSink) kinds() []EventKind {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kinds := make([]EventKind, len(s.events))
+	for i, e := range s.events {
+		kinds[i] = e.Kind
+	}
+	return kinds
+}
+
+func (s *recordingSink) snapshot() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+// Makes sure a configured MetricsSink sees connect, TTFB and byte-count events
+func TestMetricsEmitsHTTPEvents(t *testing.T) {
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	sink := &recordingSink{}
+	f, err := New(Metrics(sink))
+	require.NoError(t, err)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	re, _, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, re.StatusCode)
+
+	kinds := sink.kinds()
+	require.Contains(t, kinds, EventConnectDone)
+	require.Contains(t, kinds, EventFirstResponseByte)
+	require.Contains(t, kinds, EventBytesRead)
+	require.Contains(t, kinds, EventBytesWritten)
+}
+
+// Makes sure a failed websocket upgrade emits EventWebsocketUpgrade{Success: false}
+func TestMetricsEmitsWebsocketUpgradeFailed(t *testing.T) {
+	sink := &recordingSink{}
+	f, err := New(Metrics(sink))
+	require.NoError(t, err)
+
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "access denied", http.StatusForbidden)
+	})
+	defer srv.Close()
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	conn, err := net.DialTimeout("tcp", proxy.Listener.Addr().String(), dialTimeout)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	config := newWebsocketConfig(proxy.Listener.Addr().String(), "/ws")
+	_, err = websocket.NewClient(config, conn)
+	require.Error(t, err)
+
+	var found bool
+	for _, e := range sink.snapshot() {
+		if e.Kind == EventWebsocketUpgrade && !e.Success {
+			found = true
+		}
+	}
+	require.True(t, found)
+}
+
+// Makes sure serveFullDuplex reports upgrade and byte-count events like the
+// HTTP/1.1 websocket path does
+func TestMetricsEmitsExtendedConnectEvents(t *testing.T) {
+	backendH2s := &http2.Server{}
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		flusher := w.(http.Flusher)
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		buf := make([]byte, 1024)
+		for {
+			n, err := req.Body.Read(buf)
+			if n > 0 {
+				w.Write(buf[:n])
+				flusher.Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}), backendH2s))
+	defer backend.Close()
+
+	sink := &recordingSink{}
+	f, err := New(H2C(true), Metrics(sink))
+	require.NoError(t, err)
+
+	proxyH2s := &http2.Server{}
+	proxy := httptest.NewServer(h2c.NewHandler(testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(backend.URL)
+		f.ServeHTTP(w, req)
+	}), proxyH2s))
+	defer proxy.Close()
+
+	client := &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}
+
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodConnect, "http://"+proxy.Listener.Addr().String()+"/ws", pr)
+	require.NoError(t, err)
+	req.Header.Set(":protocol", "websocket")
+
+	resp, err := client.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, err = pw.Write([]byte("echo"))
+	require.NoError(t, err)
+	received := make([]byte, 4)
+	_, err = io.ReadFull(resp.Body, received)
+	require.NoError(t, err)
+	require.Equal(t, "echo", string(received))
+	require.NoError(t, pw.Close())
+
+	// Bytes-written lands asynchronously as the tunnel tears down.
+	require.Eventually(t, func() bool {
+		var upgradeOK, wrote, read bool
+		for _, e := range sink.snapshot() {
+			switch {
+			case e.Kind == EventWebsocketUpgrade && e.Success:
+				upgradeOK = true
+			case e.Kind == EventBytesWritten && e.Bytes > 0:
+				wrote = true
+			case e.Kind == EventBytesRead && e.Bytes > 0:
+				read = true
+			}
+		}
+		return upgradeOK && wrote && read
+	}, time.Second, 10*time.Millisecond, "expected upgrade, bytes-written and bytes-read events")
+}
@@ -0,0 +1,156 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy decides, after the given attempt, whether the Forwarder should
+// retry and how long it should back off before doing so.
+type RetryPolicy func(req *http.Request, resp *http.Response, err error, attempt int) (backoff time.Duration, retry bool)
+
+// Retry enables the retry subsystem and configures the policy deciding which
+// requests get retried. See MaxRetryBodyBytes for which requests are
+// eligible once they carry a body.
+func Retry(policy RetryPolicy) optSetter {
+	return func(f *Forwarder) error {
+		f.retryPolicy = policy
+		return nil
+	}
+}
+
+// MaxRetryBodyBytes caps how much of a request body the Forwarder will
+// buffer in memory to make it replayable across retry attempts. It has no
+// effect unless Retry is also set.
+func MaxRetryBodyBytes(n int64) optSetter {
+	return func(f *Forwarder) error {
+		f.maxRetryBodyBytes = n
+		return nil
+	}
+}
+
+// DefaultRetryPolicy retries idempotent or replayable requests that failed
+// with a connection-level error, up to maxAttempts, backing off by backoff
+// between tries.
+func DefaultRetryPolicy(maxAttempts int, backoff time.Duration) RetryPolicy {
+	return func(req *http.Request, resp *http.Response, err error, attempt int) (time.Duration, bool) {
+		if attempt+1 >= maxAttempts {
+			return 0, false
+		}
+		if !isRetryableRequest(req) {
+			return 0, false
+		}
+		if !isRetryableError(err) {
+			return 0, false
+		}
+		return backoff, true
+	}
+}
+
+func isRetryableRequest(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return req.GetBody != nil
+}
+
+// isRetryableError reports whether err is a transport-level failure: a
+// net.Error (including timeouts such as ResponseHeaderTimeout, which fire
+// after the backend has received the request), an EOF, or a deadline.
+func isRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// bufferRetryableBody replaces req.Body with a buffered copy and populates
+// GetBody so the body can be replayed on a retry, provided it fits within
+// limit.
+func bufferRetryableBody(req *http.Request, limit int64) {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil || limit <= 0 {
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	n, err := io.CopyN(buf, req.Body, limit+1)
+	if err != nil && err != io.EOF {
+		// Restore the unmodified body instead of dropping the drained bytes.
+		req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(buf.Bytes()), req.Body))
+		return
+	}
+	if n > limit {
+		// Body didn't fit in the cap: restore it, unretryable.
+		req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(buf.Bytes()), req.Body))
+		return
+	}
+
+	req.Body.Close()
+	body := buf.Bytes()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+}
+
+// roundTrip performs outReq against the RoundTripper, retrying per
+// f.retryPolicy when configured.
+func (f *Forwarder) roundTrip(inReq, outReq *http.Request) (*http.Response, error) {
+	if f.retryPolicy == nil {
+		return f.roundTripper.RoundTrip(outReq)
+	}
+
+	attempt := 0
+	for {
+		resp, err := f.roundTripper.RoundTrip(outReq)
+		backoff, retry := f.retryPolicy(outReq, resp, err, attempt)
+		if !retry {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		attempt++
+		if backoff > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-inReq.Context().Done():
+				return nil, inReq.Context().Err()
+			}
+		}
+
+		outReq = f.copyRequest(inReq)
+		outReq = outReq.WithContext(f.traceContext(inReq.Context(), outReq.URL.Host))
+		if outReq.GetBody != nil {
+			body, gbErr := outReq.GetBody()
+			if gbErr != nil {
+				return nil, gbErr
+			}
+			outReq.Body = body
+		}
+		if f.rewriter != nil {
+			f.rewriter.Rewrite(outReq)
+		}
+		if f.reqModifier != nil {
+			f.reqModifier(outReq)
+		}
+	}
+}
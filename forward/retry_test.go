@@ -0,0 +1,141 @@
+package forward
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gravitational/oxy/testutils"
+	"github.com/stretchr/testify/require"
+)
+
+// Makes sure a request that times out on its first attempt succeeds on retry
+func TestRetrySucceedsAfterTimeout(t *testing.T) {
+	var calls int32
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	f, err := New(
+		RoundTripper(&http.Transport{ResponseHeaderTimeout: 5 * time.Millisecond}),
+		Retry(DefaultRetryPolicy(2, time.Millisecond)),
+	)
+	require.NoError(t, err)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	re, body, err := testutils.Get(proxy.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, re.StatusCode)
+	require.Equal(t, "hello", string(body))
+	require.EqualValues(t, 2, atomic.LoadInt32(&calls))
+}
+
+// Makes sure a POST without a replayable body is never retried
+func TestRetrySkipsNonIdempotentMethodsWithoutOptIn(t *testing.T) {
+	var calls int32
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	f, err := New(
+		RoundTripper(&http.Transport{ResponseHeaderTimeout: 5 * time.Millisecond}),
+		Retry(DefaultRetryPolicy(2, time.Millisecond)),
+	)
+	require.NoError(t, err)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	re, err := http.Post(proxy.URL, "text/plain", strings.NewReader("body"))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusGatewayTimeout, re.StatusCode)
+	require.EqualValues(t, 1, atomic.LoadInt32(&calls))
+}
+
+// Makes sure a POST body within MaxRetryBodyBytes becomes retryable
+func TestRetryBuffersBodyUpToLimit(t *testing.T) {
+	var calls int32
+	var gotBody string
+	srv := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		buf := make([]byte, 4)
+		n, _ := req.Body.Read(buf)
+		gotBody = string(buf[:n])
+		w.Write([]byte("hello"))
+	})
+	defer srv.Close()
+
+	f, err := New(
+		RoundTripper(&http.Transport{ResponseHeaderTimeout: 5 * time.Millisecond}),
+		Retry(DefaultRetryPolicy(2, time.Millisecond)),
+		MaxRetryBodyBytes(1<<20),
+	)
+	require.NoError(t, err)
+
+	proxy := testutils.NewHandler(func(w http.ResponseWriter, req *http.Request) {
+		req.URL = testutils.ParseURI(srv.URL)
+		f.ServeHTTP(w, req)
+	})
+	defer proxy.Close()
+
+	req, err := http.NewRequest(http.MethodPost, proxy.URL, strings.NewReader("body"))
+	require.NoError(t, err)
+	re, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, re.StatusCode)
+	require.Equal(t, "body", gotBody)
+	require.EqualValues(t, 2, atomic.LoadInt32(&calls))
+}
+
+// errAfterReader yields data once and then fails with a non-EOF error,
+// simulating a client upload that breaks mid-body.
+type errAfterReader struct {
+	data []byte
+	err  error
+}
+
+func (r *errAfterReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// Makes sure a mid-body read error restores the drained bytes into req.Body
+func TestBufferRetryableBodyRestoresBodyOnReadError(t *testing.T) {
+	readErr := errors.New("boom")
+	req, err := http.NewRequest(http.MethodPost, "http://example.com", nil)
+	require.NoError(t, err)
+	req.Body = io.NopCloser(&errAfterReader{data: []byte("partial body"), err: readErr})
+
+	bufferRetryableBody(req, 1<<20)
+
+	require.Nil(t, req.GetBody)
+	body, err := io.ReadAll(req.Body)
+	require.ErrorIs(t, err, readErr)
+	require.Equal(t, "partial body", string(body))
+}
@@ -0,0 +1,132 @@
+package forward
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Forwarded is the RFC 7239 standardized successor to the de-facto
+// X-Forwarded-* header set.
+const Forwarded = "Forwarded"
+
+// HeaderRewriter is responsible for removing hop-by-hop headers and setting
+// forwarding headers
+type HeaderRewriter struct {
+	TrustForwardHeader bool
+	Hostname           string
+
+	// SuppressXForwarded, when true, omits the legacy X-Forwarded-* headers
+	// and emits only the RFC 7239 Forwarded header.
+	SuppressXForwarded bool
+}
+
+// NewHeaderRewriter creates a new HeaderRewriter
+func NewHeaderRewriter() *HeaderRewriter {
+	return &HeaderRewriter{}
+}
+
+// Rewrite removes hop-by-hop headers and sets the X-Forwarded-* and
+// Forwarded headers on the outbound request.
+func (rw *HeaderRewriter) Rewrite(req *http.Request) {
+	if !isWebsocketRequest(req) {
+		removeHeaders(req.Header, HopHeaders...)
+	}
+
+	host, port, err := net.SplitHostPort(req.RemoteAddr)
+	var clientIP, forNode string
+	if err == nil {
+		clientIP = ipv6fix(host)
+		forNode = clientIP
+		if strings.Contains(clientIP, ":") {
+			// Only IPv6 literals carry their port in the for= param; doing
+			// the same for IPv4 would make it look like part of the address.
+			forNode = net.JoinHostPort(clientIP, port)
+		}
+	}
+
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+
+	if !rw.SuppressXForwarded {
+		rw.rewriteXForwarded(req, clientIP, proto)
+	}
+	rw.rewriteForwarded(req, forNode, proto)
+}
+
+func (rw *HeaderRewriter) rewriteXForwarded(req *http.Request, clientIP, proto string) {
+	if clientIP != "" {
+		xff := clientIP
+		if rw.TrustForwardHeader {
+			if prior, ok := req.Header[XForwardedFor]; ok {
+				xff = strings.Join(prior, ", ") + ", " + clientIP
+			}
+		}
+		req.Header.Set(XForwardedFor, xff)
+	}
+
+	if xfp := req.Header.Get(XForwardedProto); xfp == "" || !rw.TrustForwardHeader {
+		req.Header.Set(XForwardedProto, proto)
+	}
+
+	if xfh := req.Header.Get(XForwardedHost); xfh == "" || !rw.TrustForwardHeader {
+		if req.Host != "" {
+			req.Header.Set(XForwardedHost, req.Host)
+		}
+	}
+
+	req.Header.Set(XForwardedServer, rw.Hostname)
+}
+
+// rewriteForwarded sets the standardized RFC 7239 Forwarded header.
+func (rw *HeaderRewriter) rewriteForwarded(req *http.Request, forNode, proto string) {
+	var params []string
+	if forNode != "" {
+		params = append(params, "for="+quoteForwardedNode(forNode))
+	}
+	if rw.Hostname != "" {
+		params = append(params, "by="+quoteForwardedNode(rw.Hostname))
+	}
+	if req.Host != "" {
+		params = append(params, "host="+quoteForwardedNode(req.Host))
+	}
+	params = append(params, "proto="+proto)
+	element := strings.Join(params, ";")
+
+	if rw.TrustForwardHeader {
+		if prior := req.Header.Get(Forwarded); prior != "" {
+			req.Header.Set(Forwarded, prior+", "+element)
+			return
+		}
+	}
+	req.Header.Set(Forwarded, element)
+}
+
+// quoteForwardedNode formats a node identifier as a Forwarded header value,
+// quoting it when it contains a colon and bracketing any IPv6 literal as
+// required by RFC 7239; other identifiers are returned unquoted.
+func quoteForwardedNode(node string) string {
+	if !strings.Contains(node, ":") {
+		return node
+	}
+	if host, port, err := net.SplitHostPort(node); err == nil {
+		if strings.Contains(host, ":") {
+			return `"[` + host + `]:` + port + `"`
+		}
+		return `"` + host + `:` + port + `"`
+	}
+	// A bare address with no port: if it has more than one colon it's an
+	// unbracketed IPv6 literal and needs brackets before quoting.
+	if strings.Count(node, ":") > 1 {
+		return `"[` + node + `]"`
+	}
+	return `"` + node + `"`
+}
+
+// ipv6fix strips the zone identifier (everything from the first "%") from an
+// IPv6 literal; net.SplitHostPort keeps the zone in the host it returns.
+func ipv6fix(clientIP string) string {
+	return strings.Split(clientIP, "%")[0]
+}
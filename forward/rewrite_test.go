@@ -94,3 +94,74 @@ func populateHopHeaders(req *http.Request) {
 		req.Header.Set(hopHeader, hopHeader)
 	}
 }
+
+func TestForwardedHeaderMultiHop(t *testing.T) {
+	hr := &HeaderRewriter{TrustForwardHeader: true, Hostname: "gw"}
+
+	req := &http.Request{
+		Header:     http.Header{Forwarded: []string{"for=192.0.2.60;proto=http"}},
+		RemoteAddr: "203.0.113.5:4711",
+		Host:       "example.com",
+	}
+	hr.Rewrite(req)
+
+	assert.Equal(t,
+		`for=192.0.2.60;proto=http, for=203.0.113.5;by=gw;host=example.com;proto=http`,
+		req.Header.Get(Forwarded),
+	)
+}
+
+func TestForwardedHeaderIPv6(t *testing.T) {
+	hr := &HeaderRewriter{Hostname: "gw"}
+
+	req := &http.Request{
+		Header:     http.Header{},
+		RemoteAddr: "[2001:db8::1]:4711",
+		Host:       "example.com",
+	}
+	hr.Rewrite(req)
+
+	assert.Equal(t, `for="[2001:db8::1]:4711";by=gw;host=example.com;proto=http`, req.Header.Get(Forwarded))
+}
+
+func TestForwardedHeaderReplacesWhenNotTrusted(t *testing.T) {
+	hr := &HeaderRewriter{Hostname: "gw"}
+
+	req := &http.Request{
+		Header:     http.Header{Forwarded: []string{"for=_hidden;proto=https"}},
+		RemoteAddr: "203.0.113.5:4711",
+		Host:       "example.com",
+	}
+	hr.Rewrite(req)
+
+	assert.Equal(t, `for=203.0.113.5;by=gw;host=example.com;proto=http`, req.Header.Get(Forwarded))
+}
+
+func TestForwardedAndXForwardedForStayInSync(t *testing.T) {
+	hr := &HeaderRewriter{TrustForwardHeader: true, Hostname: "gw"}
+
+	req := &http.Request{
+		Header:     http.Header{XForwardedFor: []string{"192.0.2.60"}},
+		RemoteAddr: "203.0.113.5:4711",
+		Host:       "example.com",
+	}
+	hr.Rewrite(req)
+
+	assert.Equal(t, "192.0.2.60, 203.0.113.5", req.Header.Get(XForwardedFor))
+	assert.Contains(t, req.Header.Get(Forwarded), "for=203.0.113.5")
+}
+
+func TestForwardedSuppressesXForwarded(t *testing.T) {
+	hr := &HeaderRewriter{Hostname: "gw", SuppressXForwarded: true}
+
+	req := &http.Request{
+		Header:     http.Header{},
+		RemoteAddr: "203.0.113.5:4711",
+		Host:       "example.com",
+	}
+	hr.Rewrite(req)
+
+	assert.Empty(t, req.Header.Get(XForwardedFor))
+	assert.Empty(t, req.Header.Get(XForwardedProto))
+	assert.NotEmpty(t, req.Header.Get(Forwarded))
+}